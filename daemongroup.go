@@ -15,11 +15,24 @@
 package daemongroup
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
 	"runtime/debug"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
+// defaultHammerTime is how long StartContext waits for daemons to react to Stop
+// before giving up and returning anyway.
+const defaultHammerTime = 10 * time.Second
+
 // Logger is a generic logger interface. This help the module not to be tied to the standard library's logger.
 type Logger interface {
 	Print(v ...interface{})
@@ -32,10 +45,340 @@ type Daemon interface {
 	Start() error
 }
 
+// Stopper is an optional interface a Daemon can implement to be notified of a
+// graceful shutdown. If a Daemon implements Stopper, DaemonGroup calls Stop
+// instead of just abandoning its goroutine when the group is shutting down.
+type Stopper interface {
+	Stop(ctx context.Context) error
+}
+
+// RestartPolicy controls whether and how a daemon gets restarted after it
+// fails.
+type RestartPolicy struct {
+	// MaxAttempts is how many times the daemon may be restarted before the
+	// group gives up on it permanently. Zero means never restart; a
+	// negative value means unlimited attempts.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first restart attempt.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the exponentially growing delay between restarts.
+	// Zero or negative means uncapped.
+	MaxBackoff time.Duration
+
+	// Multiplier is applied to the backoff after every failed attempt.
+	Multiplier float64
+
+	// HealthyAfter is how long the daemon has to stay up before its
+	// restart counter and backoff reset to the initial state. Zero or
+	// negative disables the reset, so MaxAttempts counts failures from
+	// the very first start.
+	HealthyAfter time.Duration
+}
+
+// DefaultRestartPolicy is the policy AddDaemon uses when restart is true:
+// unlimited restarts with a backoff growing from 1s to 30s, and a 10s
+// healthy threshold, mirroring Consul's proxy daemon.
+var DefaultRestartPolicy = RestartPolicy{
+	MaxAttempts:    -1,
+	InitialBackoff: time.Second,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+	HealthyAfter:   10 * time.Second,
+}
+
+func (p RestartPolicy) allowsRestart(attempts int) bool {
+	return p.MaxAttempts < 0 || attempts < p.MaxAttempts
+}
+
+func (p RestartPolicy) nextBackoff(current time.Duration) time.Duration {
+	if current <= 0 {
+		current = p.InitialBackoff
+	}
+
+	next := time.Duration(float64(current) * p.Multiplier)
+	if p.MaxBackoff > 0 && next > p.MaxBackoff {
+		next = p.MaxBackoff
+	}
+
+	return next
+}
+
+// LimitAware is an optional interface a Daemon can implement to receive the
+// Limiter guarding its own (re)starts, so it can reuse the same token
+// bucket to throttle its inner work loop.
+type LimitAware interface {
+	SetLimiter(l *Limiter)
+}
+
+// Limiter is a token-bucket rate limiter.
+type Limiter struct {
+	mu        sync.Mutex
+	tokens    float64
+	burst     float64
+	rate      float64 // tokens per second
+	unlimited bool
+	last      time.Time
+}
+
+// NewLimiter creates a Limiter that allows up to rate events per `per`,
+// with up to burst events allowed back to back after the limiter has been
+// idle; the bucket starts full. A burst below one is treated as one. A rate
+// of zero or less disables limiting entirely: Allow always succeeds and Wait
+// always returns immediately, rather than dividing by zero.
+func NewLimiter(rate int, per time.Duration, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Limiter{
+		tokens:    float64(burst),
+		burst:     float64(burst),
+		rate:      float64(rate) / per.Seconds(),
+		unlimited: rate <= 0,
+		last:      time.Now(),
+	}
+}
+
+// Allow reports whether an event may proceed right now, consuming a token
+// if so.
+func (l *Limiter) Allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.unlimited {
+		return true
+	}
+
+	l.refill()
+	if l.tokens < 1 {
+		return false
+	}
+
+	l.tokens--
+	return true
+}
+
+// Wait blocks until a token is available or ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.unlimited {
+			l.mu.Unlock()
+			return nil
+		}
+		l.refill()
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (l *Limiter) refill() {
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
+
 type daemonData struct {
 	Daemon
 	Name    string
-	Restart bool
+	Policy  RestartPolicy
+	Limiter *Limiter
+	Deps    []string
+}
+
+// Phase is a daemon's position in its lifecycle, as tracked by DaemonGroup.
+type Phase int
+
+// The lifecycle phases a daemon moves through. A daemon with a restart
+// policy cycles between Starting and Running until it either stops cleanly
+// (Stopped) or its RestartPolicy gives up (Failed). Stopping is entered when
+// the group calls Stop on a daemon that implements Stopper.
+const (
+	Registered Phase = iota
+	Starting
+	Running
+	Stopping
+	Stopped
+	Failed
+)
+
+// String implements fmt.Stringer.
+func (p Phase) String() string {
+	switch p {
+	case Registered:
+		return "registered"
+	case Starting:
+		return "starting"
+	case Running:
+		return "running"
+	case Stopping:
+		return "stopping"
+	case Stopped:
+		return "stopped"
+	case Failed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalJSON implements json.Marshaler, encoding a Phase as its String().
+func (p Phase) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.String())
+}
+
+// daemonStateBox holds a daemon's current phase and counters, and lets
+// callers block until the phase changes, similarly to a condition variable.
+type daemonStateBox struct {
+	mu        sync.Mutex
+	phase     Phase
+	ch        chan struct{}
+	startedAt time.Time
+	starts    int
+	restarts  int
+	panics    int
+	lastErr   error
+}
+
+func newDaemonStateBox() *daemonStateBox {
+	return &daemonStateBox{phase: Registered, ch: make(chan struct{})}
+}
+
+func (b *daemonStateBox) get() Phase {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.phase
+}
+
+// set stores the new phase and returns the old phase plus the channel that
+// callers blocked in waitCh should have been waiting on, so the caller can
+// close it to wake them up.
+func (b *daemonStateBox) set(p Phase) (old Phase, waiters chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	old, waiters = b.phase, b.ch
+	b.phase = p
+	b.ch = make(chan struct{})
+	if p == Running {
+		b.startedAt = time.Now()
+	}
+
+	return
+}
+
+func (b *daemonStateBox) waitCh() chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.ch
+}
+
+func (b *daemonStateBox) recordStart(restart bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.starts++
+	if restart {
+		b.restarts++
+	}
+}
+
+func (b *daemonStateBox) recordError(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastErr = err
+
+	var panicErr *daemonPanicError
+	if errors.As(err, &panicErr) {
+		b.panics++
+	}
+}
+
+func (b *daemonStateBox) snapshot(name string) DaemonStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var uptime time.Duration
+	if b.phase == Running {
+		uptime = time.Since(b.startedAt)
+	}
+
+	var lastError string
+	if b.lastErr != nil {
+		lastError = b.lastErr.Error()
+	}
+
+	return DaemonStats{
+		Name:      name,
+		Phase:     b.phase,
+		Starts:    b.starts,
+		Restarts:  b.restarts,
+		Panics:    b.panics,
+		LastError: lastError,
+		Uptime:    uptime,
+	}
+}
+
+// DaemonStats is a snapshot of a single daemon's counters, as returned by
+// Stats and served by Handler.
+type DaemonStats struct {
+	Name      string        `json:"name"`
+	Phase     Phase         `json:"phase"`
+	Starts    int           `json:"starts"`
+	Restarts  int           `json:"restarts"`
+	Panics    int           `json:"panics"`
+	LastError string        `json:"lastError,omitempty"`
+	Uptime    time.Duration `json:"uptime"`
+}
+
+// MetricsSink receives every phase transition, so callers can wire
+// DaemonGroup into Prometheus, expvar, or similar.
+type MetricsSink interface {
+	OnStateChange(name string, old, new Phase)
+}
+
+// DaemonOption configures optional behavior when adding a daemon with
+// AddDaemonWithOptions.
+type DaemonOption func(*daemonData)
+
+// DependsOn returns a DaemonOption that makes the daemon wait until every
+// named dependency has reached the Running phase before it is started
+// itself. The dependencies must be added to the group under those names.
+func DependsOn(names ...string) DaemonOption {
+	return func(d *daemonData) {
+		d.Deps = append(d.Deps, names...)
+	}
+}
+
+// RateLimit returns a DaemonOption that caps how often the daemon may be
+// (re)started to at most rate attempts per `per`, with up to burst attempts
+// allowed back to back after the daemon has been idle. This keeps a daemon that
+// panics or exits immediately from busy-looping the CPU. If the daemon
+// implements LimitAware, the same Limiter is handed to it via SetLimiter so
+// it can throttle its own inner work loop too.
+func RateLimit(rate int, per time.Duration, burst int) DaemonOption {
+	return func(d *daemonData) {
+		d.Limiter = NewLimiter(rate, per, burst)
+	}
 }
 
 // DaemonGroup manages daemons.
@@ -43,64 +386,514 @@ type daemonData struct {
 // If daemon panics or exists, it logs the result, and depending on the configuration,
 // the daemon might be restarted.
 type DaemonGroup struct {
-	daemons []daemonData
-	logger  Logger
+	daemons         []daemonData
+	logger          Logger
+	shutdownSignals []os.Signal
+	hammerTime      time.Duration
+	states          map[string]*daemonStateBox
+	observers       []func(name string, old, new Phase)
+	metricsSinks    []MetricsSink
+
+	runMu       sync.Mutex
+	wg          sync.WaitGroup
+	shutdownCtx context.Context
+	running     bool
 }
 
 // Creates a new daemon group with a logger.
 func NewDaemonGroup(l Logger) *DaemonGroup {
 	return &DaemonGroup{
-		logger: l,
+		logger:          l,
+		shutdownSignals: []os.Signal{os.Interrupt, syscall.SIGTERM},
+		hammerTime:      defaultHammerTime,
+		states:          make(map[string]*daemonStateBox),
 	}
 }
 
-// Adds a daemon to the DaemonGroup.
+// OnStateChange registers an observer called after every phase transition,
+// in the order observers were added.
 //
 // This method is not thread-safe, do not call this after the daemon group started.
-// TODO(tamasd): restart should be a number instead of a bool.
-func (dg *DaemonGroup) AddDaemon(d Daemon, name string, restart bool) *DaemonGroup {
-	dg.daemons = append(dg.daemons, daemonData{
-		Daemon:  d,
-		Name:    name,
-		Restart: restart,
+func (dg *DaemonGroup) OnStateChange(f func(name string, old, new Phase)) *DaemonGroup {
+	dg.observers = append(dg.observers, f)
+
+	return dg
+}
+
+// AddMetricsSink registers a MetricsSink that is notified of every phase
+// transition, in addition to any observers registered via OnStateChange.
+//
+// This method is not thread-safe, do not call this after the daemon group started.
+func (dg *DaemonGroup) AddMetricsSink(s MetricsSink) *DaemonGroup {
+	dg.metricsSinks = append(dg.metricsSinks, s)
+
+	return dg
+}
+
+// Stats returns a snapshot of every daemon's counters and current phase.
+func (dg *DaemonGroup) Stats() []DaemonStats {
+	stats := make([]DaemonStats, 0, len(dg.daemons))
+	for _, d := range dg.daemons {
+		stats = append(stats, dg.states[d.Name].snapshot(d.Name))
+	}
+
+	return stats
+}
+
+// Handler returns an http.Handler serving the group's Stats as JSON at its
+// root, and triggering a restart of a specific Failed or Stopped daemon at
+// POST /restart/{name}. Every request is passed to auth first; if auth
+// returns false, the handler responds with 403 Forbidden.
+func (dg *DaemonGroup) Handler(auth func(r *http.Request) bool) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if !auth(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(dg.Stats()); err != nil {
+			dg.logger.Println(err)
+		}
+	})
+
+	mux.HandleFunc("/restart/", func(w http.ResponseWriter, r *http.Request) {
+		if !auth(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, "/restart/")
+		if err := dg.restart(name); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
 	})
 
+	return mux
+}
+
+// Wait blocks until the named daemon reaches phase. It returns an error if
+// the daemon is unknown, or if the daemon reaches Failed or Stopped without
+// ever reaching phase.
+func (dg *DaemonGroup) Wait(name string, phase Phase) error {
+	box, ok := dg.states[name]
+	if !ok {
+		return fmt.Errorf("daemongroup: unknown daemon %q", name)
+	}
+
+	for {
+		ch := box.waitCh()
+		current := box.get()
+		if current == phase {
+			return nil
+		}
+		if (current == Failed || current == Stopped) && phase != current {
+			return fmt.Errorf("daemongroup: daemon %q reached %s before reaching %s", name, current, phase)
+		}
+
+		<-ch
+	}
+}
+
+// setPhase moves the named daemon to phase and notifies observers and
+// anyone blocked in Wait.
+func (dg *DaemonGroup) setPhase(name string, phase Phase) {
+	box, ok := dg.states[name]
+	if !ok {
+		return
+	}
+
+	old, waiters := box.set(phase)
+	close(waiters)
+
+	if old == phase {
+		return
+	}
+	for _, observe := range dg.observers {
+		observe(name, old, phase)
+	}
+	for _, sink := range dg.metricsSinks {
+		sink.OnStateChange(name, old, phase)
+	}
+}
+
+// SetShutdownSignals overrides the OS signals that trigger a graceful
+// shutdown in StartContext. The default is SIGINT and SIGTERM.
+//
+// This method is not thread-safe, do not call this after the daemon group started.
+func (dg *DaemonGroup) SetShutdownSignals(signals ...os.Signal) *DaemonGroup {
+	dg.shutdownSignals = signals
+
+	return dg
+}
+
+// SetHammerTime sets how long StartContext waits for daemons to stop after a
+// shutdown is requested before giving up and returning anyway.
+//
+// This method is not thread-safe, do not call this after the daemon group started.
+func (dg *DaemonGroup) SetHammerTime(d time.Duration) *DaemonGroup {
+	dg.hammerTime = d
+
 	return dg
 }
 
+// Adds a daemon to the DaemonGroup. If restart is true, the daemon is
+// restarted with DefaultRestartPolicy whenever it fails; use
+// AddDaemonWithPolicy for more control.
+//
+// This method is not thread-safe, do not call this after the daemon group started.
+func (dg *DaemonGroup) AddDaemon(d Daemon, name string, restart bool) *DaemonGroup {
+	policy := RestartPolicy{}
+	if restart {
+		policy = DefaultRestartPolicy
+	}
+
+	return dg.AddDaemonWithPolicy(d, name, policy)
+}
+
+// AddDaemonWithPolicy adds a daemon to the DaemonGroup with full control
+// over whether and how it gets restarted after it fails. See RestartPolicy.
+//
+// This method is not thread-safe, do not call this after the daemon group started.
+func (dg *DaemonGroup) AddDaemonWithPolicy(d Daemon, name string, p RestartPolicy) *DaemonGroup {
+	return dg.AddDaemonWithOptions(d, name, p)
+}
+
+// AddDaemonWithOptions adds a daemon to the DaemonGroup with a RestartPolicy
+// and any additional DaemonOptions, such as RateLimit or DependsOn.
+//
+// This method is not thread-safe, do not call this after the daemon group started.
+func (dg *DaemonGroup) AddDaemonWithOptions(d Daemon, name string, p RestartPolicy, opts ...DaemonOption) *DaemonGroup {
+	data := daemonData{
+		Daemon: d,
+		Name:   name,
+		Policy: p,
+	}
+	for _, opt := range opts {
+		opt(&data)
+	}
+
+	dg.daemons = append(dg.daemons, data)
+	dg.states[name] = newDaemonStateBox()
+
+	return dg
+}
+
+// AddDaemonWithDeps adds a daemon that is only started once every named
+// dependency has reached the Running phase. Dependencies must refer to
+// daemons already added to the group under those names.
+//
+// This method is not thread-safe, do not call this after the daemon group started.
+func (dg *DaemonGroup) AddDaemonWithDeps(d Daemon, name string, deps ...string) *DaemonGroup {
+	return dg.AddDaemonWithOptions(d, name, RestartPolicy{}, DependsOn(deps...))
+}
+
 // Starts a DaemonGroup.
 //
-// This method blocks until all the daemons are finished running. If at least one of
-// the daemons has restart enabled, this method will block forever.
+// This method blocks until all the daemons are finished running or a shutdown
+// signal arrives. If at least one of the daemons restarts without limit
+// (MaxAttempts < 0, as with AddDaemon's restart set to true) and no signal
+// arrives, this method will block forever.
 func (dg *DaemonGroup) Start() error {
-	var wg sync.WaitGroup
+	return dg.StartContext(context.Background())
+}
+
+// StartContext starts a DaemonGroup like Start, but also watches ctx and the
+// configured shutdown signals (SIGINT/SIGTERM by default, see
+// SetShutdownSignals) for a shutdown request. When either fires, Stop is
+// called on every daemon that implements Stopper, and StartContext waits up
+// to the configured hammer time (see SetHammerTime) for them to return
+// before giving up.
+func (dg *DaemonGroup) StartContext(ctx context.Context) error {
+	if err := dg.validateDeps(); err != nil {
+		return err
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, dg.shutdownSignals...)
+	defer signal.Stop(sigCh)
+
+	shutdown, cancelShutdown := context.WithCancel(context.Background())
+	defer cancelShutdown()
+
+	dg.runMu.Lock()
+	dg.shutdownCtx = shutdown
+	dg.running = true
+	dg.runMu.Unlock()
+	defer func() {
+		dg.runMu.Lock()
+		dg.running = false
+		dg.runMu.Unlock()
+	}()
 
-	wg.Add(len(dg.daemons))
 	for _, d := range dg.daemons {
-		go func(d daemonData) {
-			defer wg.Done()
-			for {
-				if err := dg.startDaemon(d.Daemon, d.Name); err != nil {
-					dg.logger.Println(err)
-					if d.Restart {
-						dg.logger.Printf("daemon %s failed, restarting...\n", d.Name)
-						continue
-					}
-				}
-				dg.logger.Printf("daemon %s stopped\n", d.Name)
+		dg.launch(d)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		dg.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	case <-sigCh:
+	}
+
+	dg.logger.Println("shutting down...")
+	cancelShutdown()
+
+	hammerCtx, cancelHammer := context.WithTimeout(context.Background(), dg.hammerTime)
+	defer cancelHammer()
+
+	dg.stopDaemons(hammerCtx)
+
+	select {
+	case <-done:
+	case <-hammerCtx.Done():
+		dg.logger.Println("hammer time elapsed, some daemons did not stop in time")
+	}
+
+	return nil
+}
+
+// launch starts a goroutine running d, tracked by dg.wg so that
+// StartContext waits for it too even if it was spawned later via restart.
+func (dg *DaemonGroup) launch(d daemonData) {
+	dg.wg.Add(1)
+	go func() {
+		defer dg.wg.Done()
+		dg.runDaemon(d, dg.shutdownCtx)
+	}()
+}
+
+// restart re-launches a daemon by name if it is currently Failed or
+// Stopped. It is used by the handler returned by Handler.
+func (dg *DaemonGroup) restart(name string) error {
+	dg.runMu.Lock()
+	running := dg.running
+	dg.runMu.Unlock()
+	if !running {
+		return fmt.Errorf("daemongroup: group is not running")
+	}
+
+	var data daemonData
+	found := false
+	for _, d := range dg.daemons {
+		if d.Name == name {
+			data, found = d, true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("daemongroup: unknown daemon %q", name)
+	}
+
+	switch dg.states[name].get() {
+	case Failed, Stopped:
+	default:
+		return fmt.Errorf("daemongroup: daemon %q is already running", name)
+	}
+
+	dg.launch(data)
+
+	return nil
+}
+
+// runDaemon runs a single daemon, restarting it according to its
+// RestartPolicy whenever it fails, until it stops cleanly, the policy gives
+// up, or shutdown is done. If a rate limit is configured, it also governs
+// how often the daemon may be (re)started. If the daemon depends on others,
+// it waits for them to reach Running first.
+func (dg *DaemonGroup) runDaemon(d daemonData, shutdown context.Context) {
+	box := dg.states[d.Name]
+	dg.setPhase(d.Name, Starting)
+
+	for _, dep := range d.Deps {
+		if err := dg.waitForDependency(dep, shutdown); err != nil {
+			dg.logger.Printf("daemon %s: %v\n", d.Name, err)
+			dg.setPhase(d.Name, Failed)
+			return
+		}
+	}
+
+	if la, ok := d.Daemon.(LimitAware); ok && d.Limiter != nil {
+		la.SetLimiter(d.Limiter)
+	}
+
+	attempts := 0
+	backoff := d.Policy.InitialBackoff
+
+	for {
+		if d.Limiter != nil {
+			if err := d.Limiter.Wait(shutdown); err != nil {
+				dg.setPhase(d.Name, Stopped)
 				return
 			}
-		}(d)
+		}
+
+		start := time.Now()
+		box.recordStart(attempts > 0)
+		dg.setPhase(d.Name, Running)
+		err := dg.startDaemon(d.Daemon, d.Name)
+		if err == nil {
+			dg.setPhase(d.Name, Stopped)
+			dg.logger.Printf("daemon %s stopped\n", d.Name)
+			return
+		}
+		box.recordError(err)
+		dg.logger.Println(err)
+
+		if d.Policy.HealthyAfter > 0 && time.Since(start) >= d.Policy.HealthyAfter {
+			attempts = 0
+			backoff = d.Policy.InitialBackoff
+		}
+
+		if !d.Policy.allowsRestart(attempts) {
+			dg.setPhase(d.Name, Failed)
+			dg.logger.Printf("daemon %s failed permanently after %d attempts\n", d.Name, attempts)
+			return
+		}
+
+		attempts++
+		dg.setPhase(d.Name, Starting)
+		dg.logger.Printf("daemon %s failed, restarting in %s (attempt %d)...\n", d.Name, backoff, attempts)
+
+		select {
+		case <-time.After(backoff):
+		case <-shutdown.Done():
+			dg.setPhase(d.Name, Stopped)
+			return
+		}
+
+		backoff = d.Policy.nextBackoff(backoff)
+	}
+}
+
+// waitForDependency blocks until dep reaches Running, returning an error if
+// it reaches Failed or Stopped first, or if shutdown is done first.
+func (dg *DaemonGroup) waitForDependency(dep string, shutdown context.Context) error {
+	box, ok := dg.states[dep]
+	if !ok {
+		return fmt.Errorf("unknown dependency %q", dep)
+	}
+
+	for {
+		ch := box.waitCh()
+		switch box.get() {
+		case Running:
+			return nil
+		case Failed, Stopped:
+			return fmt.Errorf("dependency %q did not start", dep)
+		}
+
+		select {
+		case <-ch:
+		case <-shutdown.Done():
+			return fmt.Errorf("shutting down while waiting for dependency %q", dep)
+		}
+	}
+}
+
+// validateDeps checks that every dependency named via DependsOn refers to a
+// known daemon and that the dependency graph has no cycles.
+func (dg *DaemonGroup) validateDeps() error {
+	byName := make(map[string]daemonData, len(dg.daemons))
+	for _, d := range dg.daemons {
+		byName[d.Name] = d
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(dg.daemons))
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("daemongroup: dependency cycle detected at %q", name)
+		case visited:
+			return nil
+		}
+
+		state[name] = visiting
+		for _, dep := range byName[name].Deps {
+			if _, ok := byName[dep]; !ok {
+				return fmt.Errorf("daemongroup: daemon %q depends on unknown daemon %q", name, dep)
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+
+		return nil
+	}
+
+	for _, d := range dg.daemons {
+		if err := visit(d.Name); err != nil {
+			return err
+		}
 	}
 
-	wg.Wait()
 	return nil
 }
 
+// stopDaemons calls Stop on every daemon that implements Stopper, in
+// parallel, and returns as soon as all of them have returned or ctx is
+// done, whichever comes first. This must hold even if a Stop implementation
+// ignores ctx and never returns, otherwise a single misbehaving daemon would
+// defeat the hammer time ctx is derived from.
+func (dg *DaemonGroup) stopDaemons(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, d := range dg.daemons {
+		stopper, ok := d.Daemon.(Stopper)
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		go func(name string, s Stopper) {
+			defer wg.Done()
+			dg.setPhase(name, Stopping)
+			if err := s.Stop(ctx); err != nil {
+				dg.logger.Printf("daemon %s stop error: %v\n", name, err)
+			}
+		}(d.Name, stopper)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
 func (dg *DaemonGroup) startDaemon(d Daemon, name string) (err error) {
 	defer func() {
 		if p := recover(); p != nil {
-			err = fmt.Errorf("daemon %s panic: %v\n", name, p)
+			err = &daemonPanicError{name: name, value: p}
 			debug.PrintStack()
 		}
 	}()
@@ -110,3 +903,15 @@ func (dg *DaemonGroup) startDaemon(d Daemon, name string) (err error) {
 
 	return
 }
+
+// daemonPanicError wraps a value recovered from a panicking Daemon.Start,
+// distinguishing it from an ordinary returned error so it can be counted by
+// DaemonStats.Panics.
+type daemonPanicError struct {
+	name  string
+	value interface{}
+}
+
+func (e *daemonPanicError) Error() string {
+	return fmt.Sprintf("daemon %s panic: %v", e.name, e.value)
+}
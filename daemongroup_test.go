@@ -0,0 +1,370 @@
+// Copyright 2015 Pronovix
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package daemongroup
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Print(v ...interface{})                 {}
+func (nopLogger) Println(v ...interface{})               {}
+func (nopLogger) Printf(format string, v ...interface{}) {}
+
+// blockingDaemon runs until its Start is never unblocked, and its Stop
+// ignores ctx, to simulate a misbehaving daemon for hammer time tests.
+type blockingDaemon struct {
+	startedCh chan struct{}
+	stopDelay time.Duration
+}
+
+func (d *blockingDaemon) Start() error {
+	close(d.startedCh)
+	select {}
+}
+
+func (d *blockingDaemon) Stop(ctx context.Context) error {
+	time.Sleep(d.stopDelay)
+	return nil
+}
+
+func TestStartContextHammerTime(t *testing.T) {
+	dg := NewDaemonGroup(nopLogger{})
+	dg.SetHammerTime(50 * time.Millisecond)
+
+	d := &blockingDaemon{startedCh: make(chan struct{}), stopDelay: time.Second}
+	dg.AddDaemon(d, "blocker", false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- dg.StartContext(ctx)
+	}()
+
+	<-d.startedCh
+	cancel()
+
+	select {
+	case <-errCh:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("StartContext did not return within the hammer time budget even though Stop ignores ctx")
+	}
+}
+
+// failOnceDaemon fails immediately on every Start and counts how many times
+// it was started.
+type failOnceDaemon struct {
+	mu     sync.Mutex
+	starts int
+}
+
+func (d *failOnceDaemon) Start() error {
+	d.mu.Lock()
+	d.starts++
+	d.mu.Unlock()
+
+	return errors.New("boom")
+}
+
+func (d *failOnceDaemon) startCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.starts
+}
+
+func TestHandlerRestartRejectsGet(t *testing.T) {
+	dg := NewDaemonGroup(nopLogger{})
+
+	d := &failOnceDaemon{}
+	dg.AddDaemon(d, "svc", false)
+
+	go dg.Start()
+
+	if err := dg.Wait("svc", Failed); err != nil {
+		t.Fatalf("expected daemon to reach Failed, got: %v", err)
+	}
+	startsBefore := d.startCount()
+
+	handler := dg.Handler(func(r *http.Request) bool { return true })
+
+	req := httptest.NewRequest(http.MethodGet, "/restart/svc", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET /restart/svc: got status %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	if got := d.startCount(); got != startsBefore {
+		t.Fatalf("GET /restart/svc must not restart the daemon, starts went from %d to %d", startsBefore, got)
+	}
+}
+
+func TestRestartPolicyAllowsRestart(t *testing.T) {
+	cases := []struct {
+		name     string
+		policy   RestartPolicy
+		attempts int
+		want     bool
+	}{
+		{"unlimited", RestartPolicy{MaxAttempts: -1}, 1000, true},
+		{"never", RestartPolicy{MaxAttempts: 0}, 0, false},
+		{"below limit", RestartPolicy{MaxAttempts: 3}, 2, true},
+		{"at limit", RestartPolicy{MaxAttempts: 3}, 3, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.policy.allowsRestart(c.attempts); got != c.want {
+				t.Errorf("allowsRestart(%d) = %v, want %v", c.attempts, got, c.want)
+			}
+		})
+	}
+}
+
+func TestRestartPolicyNextBackoff(t *testing.T) {
+	policy := RestartPolicy{
+		InitialBackoff: time.Second,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+	}
+
+	cases := []struct {
+		current time.Duration
+		want    time.Duration
+	}{
+		{0, 2 * time.Second},             // current <= 0 starts from InitialBackoff
+		{time.Second, 2 * time.Second},
+		{2 * time.Second, 4 * time.Second},
+		{4 * time.Second, 5 * time.Second}, // capped at MaxBackoff
+		{5 * time.Second, 5 * time.Second},
+	}
+
+	for _, c := range cases {
+		if got := policy.nextBackoff(c.current); got != c.want {
+			t.Errorf("nextBackoff(%s) = %s, want %s", c.current, got, c.want)
+		}
+	}
+}
+
+// flakyDaemon always fails after sleeping runFor, and counts how many times
+// it was started.
+type flakyDaemon struct {
+	mu     sync.Mutex
+	starts int
+	runFor time.Duration
+}
+
+func (d *flakyDaemon) Start() error {
+	d.mu.Lock()
+	d.starts++
+	d.mu.Unlock()
+
+	time.Sleep(d.runFor)
+	return errors.New("flaky")
+}
+
+func (d *flakyDaemon) startCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.starts
+}
+
+func TestRunDaemonGivesUpAfterMaxAttempts(t *testing.T) {
+	dg := NewDaemonGroup(nopLogger{})
+	policy := RestartPolicy{
+		MaxAttempts:    2,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		Multiplier:     1,
+		HealthyAfter:   time.Hour, // runs are instant, so this never triggers
+	}
+
+	d := &flakyDaemon{}
+	dg.AddDaemonWithPolicy(d, "svc", policy)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go dg.StartContext(ctx)
+
+	if err := dg.Wait("svc", Failed); err != nil {
+		t.Fatalf("expected daemon to reach Failed, got: %v", err)
+	}
+
+	if got, want := d.startCount(), policy.MaxAttempts+1; got != want {
+		t.Fatalf("daemon started %d times, want %d (1 initial + %d retries)", got, want, policy.MaxAttempts)
+	}
+}
+
+// TestRunDaemonZeroHealthyAfterStillEnforcesMaxAttempts guards against the
+// zero value of HealthyAfter (the common case: AddDaemonWithPolicy does not
+// default it the way AddDaemon does via DefaultRestartPolicy) being treated
+// as "always healthy", which would reset attempts on every failure and make
+// MaxAttempts never trigger.
+func TestRunDaemonZeroHealthyAfterStillEnforcesMaxAttempts(t *testing.T) {
+	dg := NewDaemonGroup(nopLogger{})
+	policy := RestartPolicy{
+		MaxAttempts:    2,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		Multiplier:     1,
+		HealthyAfter:   0,
+	}
+
+	d := &flakyDaemon{}
+	dg.AddDaemonWithPolicy(d, "svc", policy)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go dg.StartContext(ctx)
+
+	if err := dg.Wait("svc", Failed); err != nil {
+		t.Fatalf("expected daemon to reach Failed, got: %v", err)
+	}
+
+	if got, want := d.startCount(), policy.MaxAttempts+1; got != want {
+		t.Fatalf("daemon started %d times, want %d (1 initial + %d retries); HealthyAfter=0 must not reset the attempt counter", got, want, policy.MaxAttempts)
+	}
+}
+
+func TestRunDaemonHealthyResetKeepsRestarting(t *testing.T) {
+	dg := NewDaemonGroup(nopLogger{})
+	policy := RestartPolicy{
+		MaxAttempts:    1,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		Multiplier:     1,
+		HealthyAfter:   20 * time.Millisecond,
+	}
+
+	// runFor comfortably exceeds HealthyAfter, so every run resets the
+	// attempt counter and the daemon should keep restarting well past
+	// MaxAttempts instead of reaching Failed.
+	d := &flakyDaemon{runFor: 30 * time.Millisecond}
+	dg.AddDaemonWithPolicy(d, "svc", policy)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go dg.StartContext(ctx)
+
+	deadline := time.Now().Add(time.Second)
+	for d.startCount() < policy.MaxAttempts+3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got, want := d.startCount(), policy.MaxAttempts+3; got < want {
+		t.Fatalf("healthy reset should keep restarting past MaxAttempts, got only %d starts", got)
+	}
+
+	stats := dg.Stats()
+	for _, s := range stats {
+		if s.Name == "svc" && s.Phase == Failed {
+			t.Fatal("daemon should not have reached Failed: HealthyAfter should keep resetting its attempt counter")
+		}
+	}
+}
+
+// TestOnStateChangeOrdersDependenciesBeforeDependents verifies that a
+// daemon added via AddDaemonWithDeps only reaches Running once its
+// dependency has, by recording the order in which OnStateChange observes
+// each daemon becoming Running.
+func TestOnStateChangeOrdersDependenciesBeforeDependents(t *testing.T) {
+	dg := NewDaemonGroup(nopLogger{})
+	dg.SetHammerTime(50 * time.Millisecond)
+
+	var mu sync.Mutex
+	var order []string
+	dg.OnStateChange(func(name string, old, new Phase) {
+		if new != Running {
+			return
+		}
+		mu.Lock()
+		order = append(order, name)
+		mu.Unlock()
+	})
+
+	base := &blockingDaemon{startedCh: make(chan struct{})}
+	dependent := &blockingDaemon{startedCh: make(chan struct{})}
+
+	dg.AddDaemon(base, "base", false)
+	dg.AddDaemonWithDeps(dependent, "dependent", "base")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- dg.StartContext(ctx)
+	}()
+
+	select {
+	case <-dependent.startedCh:
+	case <-time.After(time.Second):
+		t.Fatal("dependent daemon never started")
+	}
+
+	cancel()
+	select {
+	case <-errCh:
+	case <-time.After(time.Second):
+		t.Fatal("StartContext did not return after cancel")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(order) != 2 || order[0] != "base" || order[1] != "dependent" {
+		t.Fatalf("expected base to reach Running before dependent, got order: %v", order)
+	}
+}
+
+// TestLimiterZeroRateDisablesLimiting guards against NewLimiter(0, ...)
+// dividing by zero: once its burst of tokens is exhausted, Wait computed a
+// wait duration of +Inf seconds, which overflows to a negative
+// time.Duration and fires immediately, turning Wait into a busy spin
+// instead of blocking. A rate of zero must disable limiting instead.
+func TestLimiterZeroRateDisablesLimiting(t *testing.T) {
+	l := NewLimiter(0, time.Second, 1)
+
+	for i := 0; i < 3; i++ {
+		if !l.Allow() {
+			t.Fatalf("Allow() returned false on call %d, want true (rate 0 disables limiting)", i)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- l.Wait(ctx) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Wait() returned %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait() did not return promptly with rate 0")
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2015 Pronovix
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package daemongroup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+)
+
+// daemonizeEnvVar marks a process as the re-exec'd, detached child so
+// Daemonize knows not to fork again.
+const daemonizeEnvVar = "DAEMONGROUP_DAEMONIZED"
+
+// daemonizeHandshakeFD is the file descriptor, inherited via
+// exec.Cmd.ExtraFiles, that daemonizeChild uses to report back to
+// daemonizeReexec whether it finished setting up successfully.
+const daemonizeHandshakeFD = 3
+
+// DaemonizeConfig configures Daemonize.
+type DaemonizeConfig struct {
+	// PidFile is where the daemonized process's PID is written and
+	// flock'd, so a second invocation can detect it and exit cleanly
+	// instead of running alongside the first. Empty disables the guard.
+	PidFile string
+
+	// LogFile is where stdout and stderr are redirected after
+	// daemonizing. Empty redirects to os.DevNull.
+	LogFile string
+
+	// WorkDir is the working directory of the daemonized process. Empty
+	// keeps the current working directory.
+	WorkDir string
+
+	// Umask is the file mode creation mask applied to the daemonized
+	// process. Zero leaves the inherited umask in place.
+	Umask int
+
+	// ReloadSignal, if non-zero, is the signal that triggers ReloadFunc.
+	// This is typically SIGHUP. SIGTERM and the other signals that stop
+	// the group are handled separately by DaemonGroup.StartContext, see
+	// SetShutdownSignals.
+	ReloadSignal syscall.Signal
+
+	// ReloadFunc is called whenever ReloadSignal is received. May be nil.
+	ReloadFunc func()
+}
+
+// Daemonize detaches the process into the background before Start or
+// StartContext is called: it re-execs itself once (marked via an
+// environment variable so it only happens once), redirects stdout/stderr to
+// cfg.LogFile, and takes an flock on cfg.PidFile so a second invocation
+// exits cleanly instead of running two copies.
+//
+// The parent process waits for the child to report whether it finished
+// setting up before exiting, so a setup failure (a bad WorkDir, a PidFile
+// already locked by another instance, ...) is returned to the caller
+// instead of being hidden behind an unconditional successful exit.
+func (dg *DaemonGroup) Daemonize(cfg DaemonizeConfig) error {
+	if os.Getenv(daemonizeEnvVar) != "1" {
+		return daemonizeReexec(cfg)
+	}
+
+	return daemonizeChild(cfg)
+}
+
+// daemonizeReexec re-executes the current binary with the same arguments,
+// detached from the controlling terminal, and waits for it to report
+// readiness over a handshake pipe before exiting.
+func daemonizeReexec(cfg DaemonizeConfig) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("daemonize: %w", err)
+	}
+
+	logPath := cfg.LogFile
+	if logPath == "" {
+		logPath = os.DevNull
+	}
+	log, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("daemonize: opening log file: %w", err)
+	}
+	defer log.Close()
+
+	handshakeRead, handshakeWrite, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("daemonize: %w", err)
+	}
+	defer handshakeRead.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonizeEnvVar+"=1")
+	cmd.Stdout = log
+	cmd.Stderr = log
+	cmd.ExtraFiles = []*os.File{handshakeWrite}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if err := cmd.Start(); err != nil {
+		handshakeWrite.Close()
+		return fmt.Errorf("daemonize: %w", err)
+	}
+	handshakeWrite.Close()
+
+	reply, err := io.ReadAll(handshakeRead)
+	if err != nil {
+		return fmt.Errorf("daemonize: reading handshake: %w", err)
+	}
+
+	if msg := string(reply); msg != "ok" {
+		if msg == "" {
+			msg = "child exited before reporting readiness"
+		}
+		return fmt.Errorf("daemonize: %s", msg)
+	}
+
+	os.Exit(0)
+	return nil
+}
+
+// daemonizeChild runs in the re-exec'd, detached process: it applies the
+// umask and working directory, takes the PID file lock, wires up the
+// reload signal, and reports the outcome back to daemonizeReexec over the
+// handshake pipe it is waiting on.
+func daemonizeChild(cfg DaemonizeConfig) error {
+	handshake := os.NewFile(uintptr(daemonizeHandshakeFD), "daemonize-handshake")
+
+	err := setupDaemonizedChild(cfg)
+	if err != nil {
+		fmt.Fprint(handshake, err.Error())
+	} else {
+		fmt.Fprint(handshake, "ok")
+	}
+	handshake.Close()
+
+	return err
+}
+
+// setupDaemonizedChild does the actual work of daemonizeChild, split out so
+// daemonizeChild can report the outcome over the handshake pipe regardless
+// of which step fails.
+func setupDaemonizedChild(cfg DaemonizeConfig) error {
+	if cfg.Umask != 0 {
+		syscall.Umask(cfg.Umask)
+	}
+
+	if cfg.WorkDir != "" {
+		if err := os.Chdir(cfg.WorkDir); err != nil {
+			return fmt.Errorf("daemonize: %w", err)
+		}
+	}
+
+	if cfg.PidFile != "" {
+		if err := lockPidFile(cfg.PidFile); err != nil {
+			return err
+		}
+	}
+
+	if cfg.ReloadSignal != 0 {
+		reloadCh := make(chan os.Signal, 1)
+		signal.Notify(reloadCh, cfg.ReloadSignal)
+		go func() {
+			for range reloadCh {
+				if cfg.ReloadFunc != nil {
+					cfg.ReloadFunc()
+				}
+			}
+		}()
+	}
+
+	return nil
+}
+
+// pidFileLock keeps the locked PID file open for the life of the process, so
+// the garbage collector cannot close it and drop the lock early.
+var pidFileLock *os.File
+
+// lockPidFile opens path, takes an exclusive non-blocking flock on it, and
+// writes the current PID. The lock is released when the process exits.
+func lockPidFile(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("daemonize: opening pid file: %w", err)
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		return fmt.Errorf("daemonize: another instance is already running (%s is locked): %w", path, err)
+	}
+
+	if err := f.Truncate(0); err != nil {
+		return fmt.Errorf("daemonize: %w", err)
+	}
+	if _, err := f.WriteString(strconv.Itoa(os.Getpid())); err != nil {
+		return fmt.Errorf("daemonize: %w", err)
+	}
+	pidFileLock = f
+
+	return nil
+}
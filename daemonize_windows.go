@@ -0,0 +1,39 @@
+// Copyright 2015 Pronovix
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+
+package daemongroup
+
+import (
+	"errors"
+	"syscall"
+)
+
+// DaemonizeConfig configures Daemonize. See the Unix implementation for the
+// meaning of each field; on Windows Daemonize always fails.
+type DaemonizeConfig struct {
+	PidFile      string
+	LogFile      string
+	WorkDir      string
+	Umask        int
+	ReloadSignal syscall.Signal
+	ReloadFunc   func()
+}
+
+// Daemonize always fails on Windows. Double-fork daemonization is a Unix
+// concept; run the process under a Windows service manager instead.
+func (dg *DaemonGroup) Daemonize(cfg DaemonizeConfig) error {
+	return errors.New("daemongroup: Daemonize is not supported on windows")
+}